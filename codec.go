@@ -0,0 +1,85 @@
+package comrot
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// Codec names a compression algorithm a RotateWriter can
+// use when archiving a rotated fragment.
+type Codec string
+
+const (
+	// CodecGzip compresses fragments with compress/gzip.
+	// It is the default.
+	CodecGzip Codec = "gzip"
+
+	// CodecZstd compresses fragments with zstd. Built with
+	// the "zstd" build tag; without it, selecting CodecZstd
+	// fails at compress time.
+	CodecZstd Codec = "zstd"
+
+	// CodecNone disables compression; rotated fragments are
+	// kept as plain files.
+	CodecNone Codec = "none"
+)
+
+// Compressor streams a rotated fragment into its archived
+// form. Compress must stream src to dst (e.g. via io.Copy)
+// rather than buffering the whole fragment in memory.
+type Compressor interface {
+	// Ext is the filename suffix appended to the archived
+	// fragment, e.g. ".gz". An empty string leaves the
+	// fragment's name unchanged.
+	Ext() string
+
+	// Compress reads src and writes its compressed form to
+	// dst. lastTime is the fragment's last-write time; gzip
+	// embeds it in the archive header so a tailer can recover
+	// an accurate timestamp even if the fragment is later
+	// renamed.
+	Compress(dst io.Writer, src io.Reader, lastTime time.Time) error
+}
+
+// newCompressor resolves codec to its Compressor, defaulting
+// to gzip for an empty or unrecognized value.
+func newCompressor(codec Codec) Compressor {
+	switch codec {
+	case CodecNone:
+		return noneCompressor{}
+	case CodecZstd:
+		return newZstdCompressor()
+	default:
+		return gzipCompressor{}
+	}
+}
+
+// gzipCompressor implements Compressor using compress/gzip.
+type gzipCompressor struct{}
+
+func (gzipCompressor) Ext() string { return ".gz" }
+
+func (gzipCompressor) Compress(dst io.Writer, src io.Reader, lastTime time.Time) error {
+	gw := gzip.NewWriter(dst)
+	if !lastTime.IsZero() {
+		gw.ModTime = lastTime
+	}
+	if _, err := io.Copy(gw, src); err != nil {
+		gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
+// noneCompressor implements Compressor as a passthrough, so
+// a fragment can still flow through the async pipeline (and
+// its retention bookkeeping) without being compressed.
+type noneCompressor struct{}
+
+func (noneCompressor) Ext() string { return "" }
+
+func (noneCompressor) Compress(dst io.Writer, src io.Reader, lastTime time.Time) error {
+	_, err := io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,31 @@
+//go:build zstd
+
+package comrot
+
+import (
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdCompressor implements Compressor using klauspost/compress/zstd.
+// Built only with the "zstd" build tag to keep the default build
+// dependency-free.
+type zstdCompressor struct{}
+
+func newZstdCompressor() Compressor { return zstdCompressor{} }
+
+func (zstdCompressor) Ext() string { return ".zst" }
+
+func (zstdCompressor) Compress(dst io.Writer, src io.Reader, lastTime time.Time) error {
+	zw, err := zstd.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,23 @@
+//go:build !zstd
+
+package comrot
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// zstdCompressor is the stand-in used when the binary is built
+// without the "zstd" build tag. Selecting CodecZstd fails at
+// compress time rather than pulling in the zstd dependency by
+// default.
+type zstdCompressor struct{}
+
+func newZstdCompressor() Compressor { return zstdCompressor{} }
+
+func (zstdCompressor) Ext() string { return ".zst" }
+
+func (zstdCompressor) Compress(dst io.Writer, src io.Reader, lastTime time.Time) error {
+	return errors.New("comrot: CodecZstd requires building with -tags zstd")
+}
@@ -4,14 +4,13 @@ package comrot
 
 import (
 	"bufio"
-	"bytes"
-	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +18,10 @@ const (
 	MaxInt  = int(^uint(0) >> 1)
 	MB      = 1 << (10 * 2)
 	TimeFmt = time.RFC3339
+
+	// compressQueueSize bounds the number of rotated
+	// fragments awaiting compression before rotate blocks.
+	compressQueueSize = 16
 )
 
 var (
@@ -42,16 +45,125 @@ type RotateWriter struct {
 	// to retain. Default is infinite.
 	MaxFiles int
 
+	// MaxAge is the maximum age of a rotated fragment.
+	// Fragments older than MaxAge are pruned by drain
+	// alongside MaxFiles. Default is unlimited.
+	MaxAge time.Duration
+
+	// RotationInterval, when non-zero, rotates the log at
+	// every interval boundary, independent of MaxSize.
+	// Boundaries are counted from the RotateAt time of day,
+	// which is midnight when RotateAt is left zero.
+	RotationInterval time.Duration
+
+	// RotateAt anchors RotationInterval-based rotation to
+	// a time-of-day offset from midnight (e.g. 0 rotates
+	// at midnight, 3*time.Hour at 03:00). Ignored unless
+	// RotationInterval is set.
+	RotateAt time.Duration
+
 	// Compress is the flag indicating whether rotated
 	// files should be compressed or not. Default is true.
 	Compress bool
 
+	// Codec selects the Compressor used when Compress is
+	// true. Default is CodecGzip.
+	Codec Codec
+
+	// Namer names and parses rotated fragments. Default is
+	// TimestampNamer.
+	Namer Namer
+
+	// ErrorHandler, if set, is called with any error from
+	// the background compression worker. Errors are
+	// otherwise dropped, since compress runs off the
+	// Write path.
+	ErrorHandler func(error)
+
+	// Sync controls how often the active file is fsynced
+	// during Write. Default is SyncNone.
+	Sync SyncPolicy
+
+	// Durable, when true, makes rotate crash-safe: the
+	// outgoing file is fsynced before rename, the rotated
+	// name is only published once its compressed form is
+	// fsynced and closed, and Open recovers or discards any
+	// leftover *.tmp fragments from a prior crash.
+	Durable bool
+
 	// fp is the handle to the current log file.
 	fp *os.File
 
 	// fsize caches the current log file size.
 	fsize int64
 
+	// lastSync is when the active file was last fsynced,
+	// used to pace SyncInterval policies.
+	lastSync time.Time
+
+	// compressorOnce resolves compressor from Codec exactly
+	// once, independent of whether the async worker ever
+	// starts (durable rotation compresses inline).
+	compressorOnce sync.Once
+
+	// nextRotation caches the next interval-rotation
+	// deadline so Write can check it without a syscall.
+	// Zero means interval-based rotation is disabled.
+	nextRotation time.Time
+
+	// compressor is the Compressor resolved from Codec.
+	compressor Compressor
+
+	// compressCh feeds rotated filenames to compressLoop.
+	compressCh chan string
+
+	// compressOnce starts the compression worker exactly
+	// once, on the first fragment that needs archiving.
+	compressOnce sync.Once
+
+	// compressWG tracks the compression worker so Close
+	// can drain it before returning.
+	compressWG sync.WaitGroup
+
+	// compressJobs tracks enqueued-but-not-yet-finished
+	// compress jobs. A seqShifter Namer's shift renames
+	// whatever rotated fragments are on disk; waiting for this
+	// to drain to zero before computing fragments for shift
+	// guarantees shift never renames a fragment a queued job
+	// still expects to find under its enqueued name. See
+	// waitForCompress.
+	compressJobs sync.WaitGroup
+
+	// fsopMu guards rotate/drain against concurrent readers:
+	// held for writing only around rename/unlink, and for
+	// reading while a reader opens a handle, so a reader
+	// never observes a half-renamed file.
+	fsopMu sync.RWMutex
+
+	// refs tracks open reader handles by absolute path, so a
+	// fragment drain wants to remove is kept around until
+	// its last reader closes.
+	refs *refCounter
+
+	// pendingDeletes holds paths drain or compress wanted to
+	// remove but that still had open readers at the time.
+	// Guarded by pendingMu rather than mu, since fsopMu-holding
+	// code touches it and mu is held by callers above fsopMu
+	// in the lock order (see releaseRef).
+	pendingDeletes map[string]bool
+
+	// pendingMu guards pendingDeletes.
+	pendingMu sync.Mutex
+
+	// refInit lazily initializes refs/pendingDeletes.
+	refInit sync.Once
+
+	// rotGen counts rotations, so a tailer following the active
+	// file can tell it was rotated out from under its open
+	// handle and reopen w.filename. Read and written with
+	// sync/atomic so followActive can poll it without mu.
+	rotGen uint64
+
 	// mu syncs writer ops.
 	mu sync.Mutex
 }
@@ -64,6 +176,7 @@ func NewRotateWriter(filename string) *RotateWriter {
 		MaxSize:  DefaultMaxSize,
 		MaxFiles: DefaultMaxFiles,
 		Compress: true,
+		Namer:    DefaultNamer,
 	}
 	err := w.Open()
 	if err != nil {
@@ -82,12 +195,21 @@ func (w *RotateWriter) Write(out []byte) (int, error) {
 		w.open()
 	}
 
-	// Rotate if write exceeds threshold.
-	if w.fsize+int64(len(out)) > int64(w.MaxSize) {
+	// Rotate if write exceeds threshold, or the next
+	// scheduled interval boundary has passed.
+	due := w.fsize+int64(len(out)) > int64(w.MaxSize)
+	if !due && !w.nextRotation.IsZero() && !time.Now().Before(w.nextRotation) {
+		due = true
+	}
+	if due {
 		w.rotate()
 	}
 
-	return w.write(out)
+	n, err := w.write(out)
+	if err == nil {
+		w.maybeSync()
+	}
+	return n, err
 }
 
 // write actually writes to the log.
@@ -97,6 +219,21 @@ func (w *RotateWriter) write(out []byte) (int, error) {
 	return n, err
 }
 
+// maybeSync fsyncs the active file per Sync's policy.
+func (w *RotateWriter) maybeSync() {
+	switch {
+	case w.Sync == SyncEveryWrite:
+		w.fp.Sync()
+		w.lastSync = time.Now()
+	case w.Sync > SyncNone:
+		if time.Since(w.lastSync) >= time.Duration(w.Sync) {
+			if w.fp.Sync() == nil {
+				w.lastSync = time.Now()
+			}
+		}
+	}
+}
+
 // Close statisfies the io.Closer interface.
 func (w *RotateWriter) Close() error {
 	w.mu.Lock()
@@ -104,13 +241,22 @@ func (w *RotateWriter) Close() error {
 	return w.close()
 }
 
-// close actually closes the writer.
+// close actually closes the writer, draining any fragments
+// still queued for compression before returning.
 func (w *RotateWriter) close() error {
+	if w.compressCh != nil {
+		close(w.compressCh)
+		w.compressWG.Wait()
+		w.compressCh = nil
+		// Let a writer reopened after Close start a new worker.
+		w.compressOnce = sync.Once{}
+	}
 	if w.fp == nil {
 		return nil
 	}
+	fp := w.fp
 	w.fp = nil
-	return w.fp.Close()
+	return fp.Close()
 }
 
 // Open opens the log file if it exists. Creates a
@@ -123,10 +269,15 @@ func (w *RotateWriter) Open() error {
 
 // open opens or creates the log file.
 func (w *RotateWriter) open() error {
+	if w.Durable {
+		w.recoverTmpFragments()
+	}
+
 	info, err := os.Stat(w.filename)
 	if os.IsNotExist(err) {
 		w.fp, err = os.Create(w.filename)
 		w.fsize = int64(0)
+		w.nextRotation = w.scheduleNext(time.Now())
 		return err
 	}
 	w.fp, err = os.OpenFile(w.filename, os.O_APPEND|os.O_WRONLY, 0644)
@@ -134,9 +285,39 @@ func (w *RotateWriter) open() error {
 		return err
 	}
 	w.fsize = info.Size()
+	// Schedule off the existing file's mtime so interval
+	// rotation survives process restarts.
+	w.nextRotation = w.scheduleNext(info.ModTime())
+
+	if w.Durable {
+		// A crash can leave fsize stale; reconcile it
+		// against a fresh Stat rather than trust the one
+		// taken before the file was opened.
+		if info, err := os.Stat(w.filename); err == nil {
+			w.fsize = info.Size()
+		}
+	}
 	return nil
 }
 
+// scheduleNext computes the next interval-rotation deadline
+// following from. Returns the zero time if RotationInterval
+// is disabled.
+func (w *RotateWriter) scheduleNext(from time.Time) time.Time {
+	if w.RotationInterval <= 0 {
+		return time.Time{}
+	}
+	// Anchor to RotateAt (zero value included: it means
+	// midnight, per its doc comment), then step forward by
+	// whole intervals until we're past from.
+	y, m, d := from.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, from.Location()).Add(w.RotateAt)
+	for !next.After(from) {
+		next = next.Add(w.RotationInterval)
+	}
+	return next
+}
+
 // Rotate performs the rotation and creation of files.
 func (w *RotateWriter) Rotate() (err error) {
 	w.mu.Lock()
@@ -148,6 +329,9 @@ func (w *RotateWriter) Rotate() (err error) {
 func (w *RotateWriter) rotate() (err error) {
 	// Close existing file if open.
 	if w.fp != nil {
+		if w.Durable {
+			w.fp.Sync()
+		}
 		err = w.fp.Close()
 		w.fp = nil
 		if err != nil {
@@ -158,116 +342,243 @@ func (w *RotateWriter) rotate() (err error) {
 	// Rename dest file if it already exists.
 	_, err = os.Stat(w.filename)
 	if err == nil {
-		rot := w.filename + "." + time.Now().Format(TimeFmt)
-		err = os.Rename(w.filename, rot)
-		if err != nil {
-			return err
+		namer := w.namerFor()
+		dir, base := filepath.Dir(w.filename), filepath.Base(w.filename)
+		if shifter, ok := namer.(seqShifter); ok {
+			w.waitForCompress()
+			existing, _ := w.fragments()
+			w.fsopMu.Lock()
+			err = shifter.shift(dir, base, existing)
+			w.fsopMu.Unlock()
+			if err != nil {
+				return err
+			}
 		}
-		if w.Compress {
-			err = w.compress(rot) // TODO: async
+		rot := filepath.Join(dir, namer.Format(base, time.Now(), 0))
+		if w.Durable {
+			if err = w.durableRotate(rot); err != nil {
+				return err
+			}
+		} else {
+			w.fsopMu.Lock()
+			err = os.Rename(w.filename, rot)
+			w.fsopMu.Unlock()
 			if err != nil {
 				return err
 			}
+			if w.Compress {
+				w.startCompressor()
+				w.enqueueCompress(rot)
+			}
 		}
 	}
 
 	// Clean up old.
 	w.drain()
 
+	atomic.AddUint64(&w.rotGen, 1)
+
 	// Create new.
 	return w.open()
 }
 
-// compress compresses a file with gzip algorithm.
-func (w *RotateWriter) compress(source string) (err error) {
-	// Read uncompressed file.
+// rotationGen returns the current rotation generation, so a
+// tailer can detect that the file it has open has since been
+// rotated out and reopen w.filename.
+func (w *RotateWriter) rotationGen() uint64 {
+	return atomic.LoadUint64(&w.rotGen)
+}
+
+// resolveCompressor resolves the Compressor for Codec exactly
+// once, whether it's needed by the async worker or a durable
+// rotate's inline compression.
+func (w *RotateWriter) resolveCompressor() Compressor {
+	w.compressorOnce.Do(func() {
+		w.compressor = newCompressor(w.Codec)
+	})
+	return w.compressor
+}
+
+// startCompressor lazily launches the background compression
+// worker. Safe to call repeatedly; only the first call has
+// any effect.
+//
+// Exactly one worker: a seqShifter Namer (NumericNamer) relies
+// on waitForCompress to keep shift from ever renaming a
+// fragment a queued job still expects to find under its
+// enqueued name, regardless of worker count, so this isn't a
+// correctness constraint — just the current throughput.
+func (w *RotateWriter) startCompressor() {
+	w.resolveCompressor()
+	w.compressOnce.Do(func() {
+		w.compressCh = make(chan string, compressQueueSize)
+		w.compressWG.Add(1)
+		go w.compressLoop()
+	})
+}
+
+// compressLoop drains rotated filenames from compressCh and
+// archives them one at a time, reporting failures via
+// ErrorHandler.
+func (w *RotateWriter) compressLoop() {
+	defer w.compressWG.Done()
+	for name := range w.compressCh {
+		if err := w.compressFragment(name); err != nil && w.ErrorHandler != nil {
+			w.ErrorHandler(err)
+		}
+		w.compressJobs.Done()
+	}
+}
+
+// enqueueCompress hands path to compressLoop, tracking it in
+// compressJobs until the job finishes.
+func (w *RotateWriter) enqueueCompress(path string) {
+	w.compressJobs.Add(1)
+	w.compressCh <- path
+}
+
+// waitForCompress blocks until every compress job enqueued so
+// far has finished. A seqShifter Namer's shift renames whatever
+// fragments it finds on disk; calling this first guarantees
+// none of them is a fragment a still-running job has open under
+// the name it was enqueued with, which would otherwise let
+// shift repoint a queued job at the wrong file (or collide with
+// the name a finished job just archived it under).
+func (w *RotateWriter) waitForCompress() {
+	w.compressJobs.Wait()
+}
+
+// compressFragment streams source through w.compressor,
+// bounding memory use regardless of fragment size, then
+// removes the uncompressed original. A codec with an empty
+// Ext (CodecNone) leaves the fragment as-is: there's nothing
+// to archive it into, and source==dest would otherwise mean
+// truncating the file out from under the reader that's
+// supposed to stream its own former contents.
+func (w *RotateWriter) compressFragment(source string) (err error) {
+	if w.compressor.Ext() == "" {
+		return nil
+	}
+
 	rawfile, err := os.Open(source)
 	if err != nil {
 		return err
 	}
 	defer rawfile.Close()
 
-	// Calculate the buffer size.
-	info, _ := rawfile.Stat()
-	rawbytes := make([]byte, info.Size())
-
-	// Read rawfile content into buffer.
-	buffer := bufio.NewReader(rawfile)
-	_, err = buffer.Read(rawbytes)
+	info, err := rawfile.Stat()
 	if err != nil {
 		return err
 	}
 
-	var buf bytes.Buffer
-	writer := gzip.NewWriter(&buf)
-	writer.Write(rawbytes)
-	writer.Close()
-
-	err = ioutil.WriteFile(source+".gz", buf.Bytes(), info.Mode())
+	dest := source + w.compressor.Ext()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
 	if err != nil {
 		return err
 	}
 
-	// Remove uncompressed.
-	go os.Remove(source)
+	if err = w.compressor.Compress(out, bufio.NewReader(rawfile), info.ModTime()); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
 
+	w.removeTracked(source)
 	return nil
 }
 
-// drain cleans old and archived files.
-func (w *RotateWriter) drain() {
-	if w.MaxFiles == MaxInt {
-		return
-	}
+// fragments lists the rotated fragments of the active file,
+// newest first, using Namer.Parse to recognize and order them.
+func (w *RotateWriter) fragments() ([]fragInfo, error) {
 	files, err := ioutil.ReadDir(filepath.Dir(w.filename))
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	// Collect log fragments.
+	namer := w.namerFor()
+	base := filepath.Base(w.filename)
 	frags := []fragInfo{}
 	for _, f := range files {
-		if f.IsDir() {
+		if f.IsDir() || f.Name() == base {
 			continue
 		}
-		if f.Name() == w.filename {
-			continue
-		}
-		base, file := filepath.Base(w.filename), f.Name()
-		// Extract timestamp from filename.
-		ts := file[len(base)+1 : len(base)+1+len(TimeFmt)]
-		t, err := time.Parse(time.RFC3339, ts)
-		if err == nil {
-			frags = append(frags, fragInfo{t, f})
+		t, seq, ok := namer.Parse(base, f.Name())
+		if ok {
+			// NumericNamer (and any other namer that orders by
+			// seq rather than by name) has no timestamp to
+			// parse and always reports a zero t. Falling back
+			// to the file's ModTime keeps MaxAge pruning from
+			// seeing every fragment as infinitely old.
+			if t.IsZero() {
+				t = f.ModTime()
+			}
+			frags = append(frags, fragInfo{t, seq, f})
 		}
 	}
 	sort.Sort(byTime(frags))
+	return frags, nil
+}
+
+// drain cleans old and archived files.
+func (w *RotateWriter) drain() {
+	if w.MaxFiles == MaxInt && w.MaxAge == 0 {
+		return
+	}
+	frags, err := w.fragments()
+	if err != nil {
+		return
+	}
 
-	// Collect deletable fragmets.
+	// Collect deletable fragments, first by age then by count.
 	deletes := []fragInfo{}
+	if w.MaxAge > 0 {
+		now := time.Now()
+		kept := frags[:0]
+		for _, f := range frags {
+			if now.Sub(f.t) > w.MaxAge {
+				deletes = append(deletes, f)
+				continue
+			}
+			kept = append(kept, f)
+		}
+		frags = kept
+	}
 	if w.MaxFiles < len(frags) {
-		deletes = frags[w.MaxFiles:]
+		deletes = append(deletes, frags[w.MaxFiles:]...)
 		frags = frags[:w.MaxFiles]
 	}
 
 	go func(fs []fragInfo) {
 		for _, f := range fs {
-			os.Remove(filepath.Join(filepath.Dir(w.filename), f.Name()))
-
+			w.removeTracked(filepath.Join(filepath.Dir(w.filename), f.Name()))
 		}
 	}(deletes)
 }
 
-// fragInfo is a log fragment with file info.
+// fragInfo is a log fragment with file info. t and seq come
+// from the configured Namer's Parse; a namer that doesn't use
+// seq leaves it zero, and a zero t is replaced by the file's
+// ModTime (see fragments).
 type fragInfo struct {
-	t time.Time
+	t   time.Time
+	seq int
 	os.FileInfo
 }
 
-// byTime implements sort.Interface for []fragInfo.
-// Sorts based on the time field and in descending order.
+// byTime implements sort.Interface for []fragInfo, ordering
+// newest first: by t descending, falling back to seq ascending
+// when two fragments carry the same t (for NumericNamer, t is
+// the fragment's ModTime).
 type byTime []fragInfo
 
-func (b byTime) Len() int           { return len(b) }
-func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
-func (b byTime) Less(i, j int) bool { return b[i].t.After(b[j].t) }
+func (b byTime) Len() int      { return len(b) }
+func (b byTime) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool {
+	if !b[i].t.Equal(b[j].t) {
+		return b[i].t.After(b[j].t)
+	}
+	return b[i].seq < b[j].seq
+}
@@ -1,11 +1,15 @@
 package comrot
 
 import (
+	"compress/gzip"
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 const (
@@ -105,13 +109,313 @@ func TestCompress(t *testing.T) {
 		Compress: true,
 	}
 	w.Open()
-	defer w.Close()
 
 	w.Write([]byte("Text that clearly exceeds the MaxSize of 10 Bytes."))
 	w.Write([]byte("Some more bytes."))
 
+	// Compression runs on a background worker; Close drains
+	// it before returning.
+	w.Close()
+
 	files, _ := filepath.Glob(logDir + "/*.gz")
 	if len(files) != 1 {
 		t.Errorf("TestCompress, got %v, want %v", len(files), 1)
 	}
 }
+
+// TestCompressCodecNone verifies that Compress combined with
+// CodecNone ("trade CPU for ratio" with compression off) keeps
+// the rotated fragment intact instead of truncating it away.
+func TestCompressCodecNone(t *testing.T) {
+	setup()
+	defer teardown()
+
+	w := &RotateWriter{
+		filename: logFile,
+		MaxSize:  56,
+		MaxFiles: 10,
+		Compress: true,
+		Codec:    CodecNone,
+	}
+	w.Open()
+
+	w.Write([]byte("Text that clearly exceeds the MaxSize of 10 Bytes."))
+	w.Write([]byte("Some more bytes."))
+	w.Close()
+
+	files, _ := ioutil.ReadDir(logDir)
+	if len(files) != 2 {
+		t.Errorf("TestCompressCodecNone, got %v files, want %v", len(files), 2)
+	}
+
+	frags, _ := filepath.Glob(logFile + ".*")
+	if len(frags) != 1 {
+		t.Fatalf("TestCompressCodecNone, got %v rotated fragments, want %v", len(frags), 1)
+	}
+	b, err := ioutil.ReadFile(frags[0])
+	if err != nil {
+		t.Fatalf("TestCompressCodecNone, failed to read rotated fragment: %v", err)
+	}
+	want := "Text that clearly exceeds the MaxSize of 10 Bytes."
+	if string(b) != want {
+		t.Errorf("TestCompressCodecNone, got %q, want %q", string(b), want)
+	}
+}
+
+// TestScheduleNext verifies interval-rotation scheduling,
+// including that a zero RotateAt anchors to midnight rather
+// than disabling anchoring.
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name             string
+		rotationInterval time.Duration
+		rotateAt         time.Duration
+		want             time.Time
+	}{
+		{
+			name:             "disabled",
+			rotationInterval: 0,
+			want:             time.Time{},
+		},
+		{
+			name:             "midnight anchor",
+			rotationInterval: 24 * time.Hour,
+			rotateAt:         0,
+			want:             time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:             "anchored at 03:00",
+			rotationInterval: 24 * time.Hour,
+			rotateAt:         3 * time.Hour,
+			want:             time.Date(2026, 1, 3, 3, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, c := range cases {
+		w := &RotateWriter{RotationInterval: c.rotationInterval, RotateAt: c.rotateAt}
+		got := w.scheduleNext(from)
+		if !got.Equal(c.want) {
+			t.Errorf("TestScheduleNext %s, got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestDrainMaxAgeFallsBackToModTime verifies that MaxAge
+// pruning still works under NumericNamer, whose Parse can't
+// report a rotation time and always returns zero: drain must
+// fall back to the fragment's file ModTime instead of treating
+// every fragment as infinitely old.
+func TestDrainMaxAgeFallsBackToModTime(t *testing.T) {
+	setup()
+	defer teardown()
+
+	w := &RotateWriter{
+		filename: logFile,
+		MaxFiles: MaxInt,
+		MaxAge:   time.Hour,
+		Namer:    NumericNamer,
+	}
+
+	fresh := filepath.Join(logDir, filename+".1")
+	stale := filepath.Join(logDir, filename+".2")
+	ioutil.WriteFile(fresh, []byte("fresh"), 0644)
+	ioutil.WriteFile(stale, []byte("stale"), 0644)
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	w.drain()
+
+	// drain deletes off a background goroutine; poll briefly
+	// for it to land rather than sleeping a fixed amount.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(stale); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("TestDrainMaxAgeFallsBackToModTime, expected stale fragment to be pruned via ModTime fallback")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("TestDrainMaxAgeFallsBackToModTime, expected fresh fragment to survive, got %v", err)
+	}
+}
+
+// TestRemoveTrackedDefersForOpenReader verifies the refcounted
+// reader API: removeTracked must not delete a path that still
+// has an open reader, and must delete it as soon as that reader
+// closes.
+func TestRemoveTrackedDefersForOpenReader(t *testing.T) {
+	setup()
+	defer teardown()
+
+	path := filepath.Join(logDir, filename+".1")
+	ioutil.WriteFile(path, []byte("fragment data"), 0644)
+
+	w := &RotateWriter{filename: logFile}
+	rc, err := w.openTrackedReader(path)
+	if err != nil {
+		t.Fatalf("openTrackedReader: %v", err)
+	}
+
+	w.removeTracked(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("TestRemoveTrackedDefersForOpenReader, expected fragment to still exist while reader is open, got %v", err)
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("TestRemoveTrackedDefersForOpenReader, expected fragment to be removed once its last reader closed")
+	}
+}
+
+// TestTailAcrossFragments verifies that Tail walks the active
+// file and its rotated, gzipped fragments together, newest
+// first.
+func TestTailAcrossFragments(t *testing.T) {
+	setup()
+	defer teardown()
+
+	w := &RotateWriter{
+		filename: logFile,
+		MaxSize:  8,
+		MaxFiles: 10,
+		Compress: true,
+	}
+	w.Open()
+
+	w.Write([]byte("line1\n"))
+	w.Write([]byte("line2\n"))
+	w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &RotateWriter{filename: logFile, MaxFiles: 10}
+	out, err := r.Tail(ctx, TailOptions{Lines: 5})
+	if err != nil {
+		t.Fatalf("Tail: %v", err)
+	}
+
+	var got []string
+	for e := range out {
+		got = append(got, string(e.Line))
+	}
+
+	want := []string{"line2", "line1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TestTailAcrossFragments, got %v, want %v", got, want)
+	}
+}
+
+// TestDurableRecoversCrashedTmp verifies that Open, under
+// Durable, recovers a ".tmp" fragment left behind by a crash
+// mid-rotation instead of leaving it stranded.
+func TestDurableRecoversCrashedTmp(t *testing.T) {
+	setup()
+	defer teardown()
+
+	crashed := filepath.Join(logDir, filename+".crashed.tmp")
+	if err := ioutil.WriteFile(crashed, []byte("leftover data"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	w := &RotateWriter{filename: logFile, MaxFiles: 10, Durable: true}
+	if err := w.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := os.Stat(crashed); !os.IsNotExist(err) {
+		t.Fatal("TestDurableRecoversCrashedTmp, expected crashed .tmp fragment to be recovered, but it's still there")
+	}
+
+	frags, err := w.fragments()
+	if err != nil {
+		t.Fatalf("fragments: %v", err)
+	}
+	if len(frags) != 1 {
+		t.Fatalf("TestDurableRecoversCrashedTmp, got %v recovered fragments, want 1", len(frags))
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(logDir, frags[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(b) != "leftover data" {
+		t.Errorf("TestDurableRecoversCrashedTmp, got %q, want %q", string(b), "leftover data")
+	}
+}
+
+// TestNumericNamerRotatesWithoutCorruption is a regression test
+// for a race between NumericNamer's shift (which renumbers
+// existing fragments on every rotation) and async compression:
+// a rotation racing ahead of the previous one's still-queued
+// compress job used to let shift repoint that job at the wrong
+// file, corrupting or losing a fragment. Two rotations here
+// must each end up compressed under the right name with the
+// right content.
+func TestNumericNamerRotatesWithoutCorruption(t *testing.T) {
+	setup()
+	defer teardown()
+
+	w := &RotateWriter{
+		filename: logFile,
+		MaxSize:  5,
+		MaxFiles: 10,
+		Compress: true,
+		Namer:    NumericNamer,
+	}
+	w.Open()
+
+	w.Write([]byte("one"))   // fits, no rotation yet.
+	w.Write([]byte("two"))   // forces rotation #1: "one" -> comrot.log.1.
+	w.Write([]byte("three")) // forces rotation #2: "two" -> comrot.log.1, "one" shifts to comrot.log.2.
+	w.Close()
+
+	frags, _ := filepath.Glob(logFile + ".*")
+	sort.Strings(frags)
+	if len(frags) != 2 {
+		t.Fatalf("TestNumericNamerRotatesWithoutCorruption, got %v fragments, want 2: %v", len(frags), frags)
+	}
+
+	want := map[string]string{
+		logFile + ".1.gz": "two",
+		logFile + ".2.gz": "one",
+	}
+	for path, wantContent := range want {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("TestNumericNamerRotatesWithoutCorruption, missing expected fragment %s: %v", path, err)
+		}
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("gzip.NewReader(%s): %v", path, err)
+		}
+		data, err := ioutil.ReadAll(gr)
+		f.Close()
+		if err != nil {
+			t.Fatalf("ReadAll(%s): %v", path, err)
+		}
+		if string(data) != wantContent {
+			t.Errorf("TestNumericNamerRotatesWithoutCorruption, %s got %q, want %q", path, string(data), wantContent)
+		}
+	}
+
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("ReadFile(logFile): %v", err)
+	}
+	if string(b) != "three" {
+		t.Errorf("TestNumericNamerRotatesWithoutCorruption, active file got %q, want %q", string(b), "three")
+	}
+}
@@ -0,0 +1,98 @@
+//go:build fsnotify
+
+package comrot
+
+import (
+	"context"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// followActive watches the active file for writes via
+// fsnotify and streams new bytes to out until ctx is done.
+// Built only with the "fsnotify" build tag, to keep the
+// default build dependency-free; see followActive in
+// follow_poll.go for the polling fallback.
+func (w *RotateWriter) followActive(ctx context.Context, out chan<- TailEntry) {
+	rc, err := w.OpenTailReader()
+	if err != nil {
+		return
+	}
+	gen := w.rotationGen()
+	defer func() { rc.Close() }()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(w.filename); err != nil {
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	drain := func() bool {
+		for {
+			n, err := rc.Read(buf)
+			if n > 0 {
+				chunk := make([]byte, n)
+				copy(chunk, buf[:n])
+				select {
+				case out <- TailEntry{Time: time.Now(), Line: chunk}:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			if err != nil {
+				return true
+			}
+		}
+	}
+
+	// reopen drains whatever's left on rc, then reopens
+	// w.filename and re-adds the watch: rotation renames the
+	// watched inode away, so the old watch (and fd) stop
+	// seeing writes to the fragment actually being written now.
+	reopen := func() bool {
+		if !drain() {
+			return false
+		}
+		watcher.Remove(w.filename)
+		rc.Close()
+		nrc, err := w.OpenTailReader()
+		if err != nil {
+			return false
+		}
+		rc = nrc
+		gen = w.rotationGen()
+		return watcher.Add(w.filename) == nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if g := w.rotationGen(); g != gen {
+				if !reopen() {
+					return
+				}
+				continue
+			}
+			if ev.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+				if !reopen() {
+					return
+				}
+				continue
+			}
+			if !drain() {
+				return
+			}
+		case <-watcher.Errors:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !fsnotify
+
+package comrot
+
+import (
+	"context"
+	"time"
+)
+
+// followPollInterval is how often followActive checks the
+// active file for growth when built without the "fsnotify"
+// tag.
+const followPollInterval = 200 * time.Millisecond
+
+// followActive polls the active file for new writes and
+// streams them to out until ctx is done. This is the default
+// build; build with -tags fsnotify for event-driven following
+// instead of polling.
+func (w *RotateWriter) followActive(ctx context.Context, out chan<- TailEntry) {
+	rc, err := w.OpenTailReader()
+	if err != nil {
+		return
+	}
+	gen := w.rotationGen()
+	defer func() { rc.Close() }()
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+
+	buf := make([]byte, 32*1024)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A rotation moved our fd's file out from under
+			// it; reopen w.filename to keep following the
+			// fragment that's actually being written to now.
+			if g := w.rotationGen(); g != gen {
+				rc.Close()
+				nrc, err := w.OpenTailReader()
+				if err != nil {
+					return
+				}
+				rc = nrc
+				gen = g
+			}
+			for {
+				n, err := rc.Read(buf)
+				if n > 0 {
+					chunk := make([]byte, n)
+					copy(chunk, buf[:n])
+					select {
+					case out <- TailEntry{Time: time.Now(), Line: chunk}:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+}
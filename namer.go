@@ -0,0 +1,150 @@
+package comrot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namer controls how comrot names rotated fragments and how
+// it parses those names back out during retention (drain) and
+// discovery (Tail).
+type Namer interface {
+	// Format returns the fragment name for base (the active
+	// file's base name, not a full path) rotated at t. seq is
+	// the fragment's position in rotation order, 0 being the
+	// newest; namers that don't use sequence numbers ignore
+	// it.
+	Format(base string, t time.Time, seq int) string
+
+	// Parse extracts the rotation time and sequence number
+	// from name, a candidate fragment of base. ok is false if
+	// name doesn't match this namer's format. A namer that
+	// doesn't encode one of t or seq returns the zero value
+	// for it.
+	Parse(base, name string) (t time.Time, seq int, ok bool)
+}
+
+var (
+	// TimestampNamer names fragments with the RFC3339
+	// timestamp of their rotation, e.g.
+	// "app.log.2024-01-02T15:04:05Z". It is the default.
+	TimestampNamer Namer = timestampNamer{}
+
+	// NumericNamer names fragments by rotation-order index,
+	// e.g. "app.log.1", "app.log.2.gz", shifting existing
+	// indices up by one on every rotation. Compatible with
+	// classic logrotate/lumberjack deployments.
+	NumericNamer Namer = numericNamer{}
+
+	// DefaultNamer is the Namer NewRotateWriter configures.
+	DefaultNamer = TimestampNamer
+)
+
+// knownCompressorExts are the suffixes a Compressor may
+// append after a Namer's own fragment name; namers strip them
+// before parsing so a compressed fragment still matches.
+var knownCompressorExts = []string{".gz", ".zst"}
+
+func trimCompressorExt(name string) string {
+	for _, ext := range knownCompressorExts {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// timestampNamer is the historical comrot naming scheme:
+// "<base>.<RFC3339>".
+type timestampNamer struct{}
+
+func (timestampNamer) Format(base string, t time.Time, seq int) string {
+	return base + "." + t.Format(TimeFmt)
+}
+
+func (timestampNamer) Parse(base, name string) (time.Time, int, bool) {
+	prefix := base + "."
+	if !strings.HasPrefix(name, prefix) {
+		return time.Time{}, 0, false
+	}
+	// RFC3339's zone component ("Z" vs "+00:00") varies in
+	// length, so rest can't be length-checked against TimeFmt
+	// up front; time.Parse itself rejects anything that isn't
+	// a full, exact match.
+	rest := trimCompressorExt(name[len(prefix):])
+	t, err := time.Parse(TimeFmt, rest)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return t, 0, true
+}
+
+// numericNamer is the classic logrotate/lumberjack scheme:
+// "<base>.<n>", n starting at 1 for the most recently rotated
+// fragment.
+type numericNamer struct{}
+
+func (numericNamer) Format(base string, t time.Time, seq int) string {
+	return fmt.Sprintf("%s.%d", base, seq+1)
+}
+
+func (numericNamer) Parse(base, name string) (time.Time, int, bool) {
+	prefix := base + "."
+	if !strings.HasPrefix(name, prefix) {
+		return time.Time{}, 0, false
+	}
+	rest := trimCompressorExt(name[len(prefix):])
+	n, err := strconv.Atoi(rest)
+	if err != nil || n < 1 {
+		return time.Time{}, 0, false
+	}
+	return time.Time{}, n - 1, true
+}
+
+// seqShifter is implemented by Namers whose Format assigns a
+// position-based suffix and therefore need existing fragments
+// renumbered before a new one is assigned seq 0. numericNamer
+// is the only built-in that needs this; timestampNamer's
+// names never collide across rotations.
+type seqShifter interface {
+	shift(dir, base string, existing []fragInfo) error
+}
+
+// shift renames every existing numbered fragment up by one
+// index, oldest (highest index) first so no rename collides
+// with one still pending.
+func (numericNamer) shift(dir, base string, existing []fragInfo) error {
+	ordered := make([]fragInfo, len(existing))
+	copy(ordered, existing)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].seq > ordered[j].seq })
+
+	for _, f := range ordered {
+		ext := ""
+		for _, e := range knownCompressorExts {
+			if strings.HasSuffix(f.Name(), e) {
+				ext = e
+				break
+			}
+		}
+		newName := fmt.Sprintf("%s.%d%s", base, f.seq+2, ext)
+		if err := os.Rename(filepath.Join(dir, f.Name()), filepath.Join(dir, newName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namerFor resolves Namer to its configured value, defaulting
+// to TimestampNamer for a RotateWriter built as a struct
+// literal rather than via NewRotateWriter.
+func (w *RotateWriter) namerFor() Namer {
+	if w.Namer == nil {
+		return TimestampNamer
+	}
+	return w.Namer
+}
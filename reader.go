@@ -0,0 +1,130 @@
+package comrot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// OpenReader opens a read-only handle to the active log file
+// that safely coexists with rotation. The handle holds a
+// reference that defers deletion of its underlying file until
+// the returned reader is closed, so a rotate racing with a
+// read never hands back a half-renamed or deleted file.
+func (w *RotateWriter) OpenReader() (io.ReadCloser, error) {
+	return w.openTrackedReader(w.filename)
+}
+
+// OpenTailReader is like OpenReader but seeks to the current
+// end of the file, for callers that only want to follow
+// writes made from this point on.
+func (w *RotateWriter) OpenTailReader() (io.ReadCloser, error) {
+	rc, err := w.openTrackedReader(w.filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rc.Seek(0, io.SeekEnd); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// openTrackedReader opens path for reading under fsopMu (read
+// side) so a concurrent rotate can't rename or remove it
+// mid-open, then registers a refCounter entry for it.
+func (w *RotateWriter) openTrackedReader(path string) (*refReader, error) {
+	w.ensureRefs()
+
+	w.fsopMu.RLock()
+	defer w.fsopMu.RUnlock()
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	w.refs.acquire(abs)
+	return &refReader{File: fp, w: w, path: abs}, nil
+}
+
+// refReader is an io.ReadCloser backed by an *os.File that
+// releases its refCounter reference on Close, deleting the
+// underlying file if rotate had deferred its removal pending
+// this reader.
+type refReader struct {
+	*os.File
+	w    *RotateWriter
+	path string
+}
+
+// Close closes the underlying file and releases its reference.
+func (r *refReader) Close() error {
+	err := r.File.Close()
+	r.w.releaseRef(r.path)
+	return err
+}
+
+// ensureRefs lazily initializes the refcounting state, so a
+// RotateWriter works whether built via NewRotateWriter or as
+// a struct literal.
+func (w *RotateWriter) ensureRefs() {
+	w.refInit.Do(func() {
+		w.refs = newRefCounter()
+		w.pendingDeletes = make(map[string]bool)
+	})
+}
+
+// releaseRef drops a reader reference on path. If it was the
+// last reference and drain had deferred deleting path, the
+// delete happens now.
+//
+// This only ever takes fsopMu, never w.mu: rotate() (called
+// with w.mu already held) takes fsopMu next, so taking w.mu
+// here too would invert that order against a concurrent
+// rotate and deadlock. pendingDeletes has its own mutex for
+// exactly this reason.
+func (w *RotateWriter) releaseRef(path string) {
+	if !w.refs.release(path) {
+		return
+	}
+
+	w.fsopMu.Lock()
+	defer w.fsopMu.Unlock()
+
+	w.pendingMu.Lock()
+	pending := w.pendingDeletes[path]
+	delete(w.pendingDeletes, path)
+	w.pendingMu.Unlock()
+
+	if pending {
+		os.Remove(path)
+	}
+}
+
+// removeTracked deletes path immediately if it has no open
+// readers, or defers the delete to whichever reader releases
+// the last reference on it. See releaseRef for why this
+// guards pendingDeletes with pendingMu rather than w.mu.
+func (w *RotateWriter) removeTracked(path string) {
+	w.ensureRefs()
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	w.fsopMu.Lock()
+	defer w.fsopMu.Unlock()
+
+	if w.refs.count(abs) > 0 {
+		w.pendingMu.Lock()
+		w.pendingDeletes[abs] = true
+		w.pendingMu.Unlock()
+		return
+	}
+	os.Remove(abs)
+}
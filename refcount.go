@@ -0,0 +1,43 @@
+package comrot
+
+import "sync"
+
+// refCounter tracks open reader handles by absolute path, so
+// rotate and drain can defer deleting a file that's still
+// being read until its last reader closes.
+type refCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// newRefCounter returns an empty refCounter.
+func newRefCounter() *refCounter {
+	return &refCounter{counts: make(map[string]int)}
+}
+
+// acquire registers a reader on path.
+func (r *refCounter) acquire(path string) {
+	r.mu.Lock()
+	r.counts[path]++
+	r.mu.Unlock()
+}
+
+// release drops a reader on path and reports whether the
+// count reached zero.
+func (r *refCounter) release(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[path]--
+	if r.counts[path] <= 0 {
+		delete(r.counts, path)
+		return true
+	}
+	return false
+}
+
+// count returns the number of open readers on path.
+func (r *refCounter) count(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[path]
+}
@@ -0,0 +1,245 @@
+package comrot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TailOptions configures Tail.
+type TailOptions struct {
+	// Lines is the number of trailing lines to return. If
+	// zero, Bytes is used instead.
+	Lines int
+
+	// Bytes is the number of trailing raw bytes to return.
+	// Only consulted when Lines is zero.
+	Bytes int64
+
+	// Follow keeps the returned channel open after the
+	// backlog has been delivered, streaming new writes to
+	// the active file until ctx is done.
+	Follow bool
+
+	// GetTailReader frames a fragment's decompressed byte
+	// stream into discrete units for counting and emitting.
+	// Defaults to plain newline-delimited lines; callers can
+	// plug in e.g. JSON-line framing instead.
+	GetTailReader GetTailReader
+}
+
+// GetTailReader adapts a fragment's byte stream into a
+// LineReader for Tail to pull successive units from.
+type GetTailReader func(r io.Reader) LineReader
+
+// LineReader yields successive frames from a fragment's byte
+// stream. ReadLine returns io.EOF once the stream is
+// exhausted.
+type LineReader interface {
+	ReadLine() ([]byte, error)
+}
+
+// plainLineReader is the default GetTailReader: newline-
+// delimited lines with the trailing newline stripped.
+type plainLineReader struct{ r *bufio.Reader }
+
+func newPlainLineReader(r io.Reader) LineReader {
+	return &plainLineReader{r: bufio.NewReader(r)}
+}
+
+func (p *plainLineReader) ReadLine() ([]byte, error) {
+	line, err := p.r.ReadBytes('\n')
+	if len(line) > 0 {
+		return bytes.TrimRight(line, "\n"), nil
+	}
+	return nil, err
+}
+
+// TailEntry is one frame emitted by Tail, paired with the time
+// its content was originally written: the fragment's ModTime
+// for a plain fragment, the gzip header's embedded ModTime for
+// a compressed one (see gzipCompressor.Compress), or the
+// current time for entries streamed live from the active file.
+// This lets a caller show accurate timestamps even after a
+// fragment has been renamed.
+type TailEntry struct {
+	Time time.Time
+	Line []byte
+}
+
+// Tail yields recent log content across the active file and
+// its rotated fragments, newest first, transparently
+// decompressing ".gz" fragments. With opts.Follow it keeps
+// streaming new writes to the active file after the backlog,
+// until ctx is done, at which point the returned channel is
+// closed.
+func (w *RotateWriter) Tail(ctx context.Context, opts TailOptions) (<-chan TailEntry, error) {
+	framer := opts.GetTailReader
+	if framer == nil {
+		framer = newPlainLineReader
+	}
+
+	sources, err := w.tailSources()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan TailEntry)
+	go func() {
+		defer close(out)
+		switch {
+		case opts.Lines > 0:
+			w.emitTrailingLines(ctx, out, sources, framer, opts.Lines)
+		case opts.Bytes > 0:
+			w.emitTrailingBytes(ctx, out, sources, opts.Bytes)
+		}
+		if opts.Follow {
+			w.followActive(ctx, out)
+		}
+	}()
+	return out, nil
+}
+
+// tailSources lists the active file followed by its rotated
+// fragments, newest first, reusing the same byTime ordering
+// drain uses for retention.
+func (w *RotateWriter) tailSources() ([]string, error) {
+	sources := []string{}
+	if _, err := os.Stat(w.filename); err == nil {
+		sources = append(sources, w.filename)
+	}
+	frags, err := w.fragments()
+	if err != nil {
+		return sources, nil
+	}
+	for _, f := range frags {
+		sources = append(sources, filepath.Join(filepath.Dir(w.filename), f.Name()))
+	}
+	return sources, nil
+}
+
+// emitTrailingLines walks sources newest-to-oldest, framing
+// each with framer, and emits the most recent n lines to out
+// in newest-first order.
+func (w *RotateWriter) emitTrailingLines(ctx context.Context, out chan<- TailEntry, sources []string, framer GetTailReader, n int) {
+	remaining := n
+	for _, src := range sources {
+		if remaining <= 0 {
+			return
+		}
+		t, lines, err := w.readFragmentLines(src, framer)
+		if err != nil {
+			continue
+		}
+		// lines is oldest-to-newest within src; walk it
+		// back-to-front so the newest line in src is
+		// emitted first.
+		for i := len(lines) - 1; i >= 0 && remaining > 0; i-- {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- TailEntry{Time: t, Line: lines[i]}:
+				remaining--
+			}
+		}
+	}
+}
+
+// readFragmentLines reads every line of src (decompressing it
+// first if needed) via framer, oldest-to-newest, alongside the
+// time src was originally written.
+func (w *RotateWriter) readFragmentLines(src string, framer GetTailReader) (time.Time, [][]byte, error) {
+	rc, t, err := w.openFragment(src)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer rc.Close()
+
+	lr := framer(rc)
+	var lines [][]byte
+	for {
+		line, err := lr.ReadLine()
+		if len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+		if err != nil {
+			break
+		}
+	}
+	return t, lines, nil
+}
+
+// emitTrailingBytes walks sources newest-to-oldest, emitting
+// raw chunks to out until roughly n trailing bytes have been
+// sent.
+func (w *RotateWriter) emitTrailingBytes(ctx context.Context, out chan<- TailEntry, sources []string, n int64) {
+	var sent int64
+	for _, src := range sources {
+		if sent >= n {
+			return
+		}
+		rc, t, err := w.openFragment(src)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			continue
+		}
+		if want := n - sent; int64(len(data)) > want {
+			data = data[int64(len(data))-want:]
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case out <- TailEntry{Time: t, Line: data}:
+			sent += int64(len(data))
+		}
+	}
+}
+
+// openFragment opens src through the refcounted reader API,
+// transparently gunzipping it if its name ends in ".gz", and
+// reports the time its content was originally written: the
+// fragment's own ModTime for a plain file, or the gzip header's
+// embedded ModTime (set by gzipCompressor from the fragment's
+// ModTime before compression) for a ".gz" one.
+func (w *RotateWriter) openFragment(src string) (io.ReadCloser, time.Time, error) {
+	rc, err := w.openTrackedReader(src)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !strings.HasSuffix(src, ".gz") {
+		t := time.Now()
+		if fi, err := rc.Stat(); err == nil {
+			t = fi.ModTime()
+		}
+		return rc, t, nil
+	}
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, time.Time{}, err
+	}
+	return &gzipFragment{Reader: gr, rc: rc}, gr.ModTime, nil
+}
+
+// gzipFragment closes both the gzip reader and the tracked
+// file handle backing it.
+type gzipFragment struct {
+	*gzip.Reader
+	rc io.ReadCloser
+}
+
+func (g *gzipFragment) Close() error {
+	g.Reader.Close()
+	return g.rc.Close()
+}